@@ -0,0 +1,50 @@
+package main
+
+import "sync"
+
+// LogBroker fans out a job's log lines to any number of live subscribers
+// (e.g. SSE clients), in addition to the lines being persisted to SQLite by
+// the caller.
+type LogBroker struct {
+	mu   sync.Mutex
+	subs map[uint32]map[chan string]struct{}
+}
+
+// NewLogBroker creates an empty LogBroker.
+func NewLogBroker() *LogBroker {
+	return &LogBroker{subs: map[uint32]map[chan string]struct{}{}}
+}
+
+// Subscribe registers a new subscriber for a job's live log lines. Call the
+// returned unsubscribe func once the subscriber is done listening.
+func (b *LogBroker) Subscribe(jobId uint32) (ch chan string, unsubscribe func()) {
+	ch = make(chan string, 64)
+
+	b.mu.Lock()
+	if b.subs[jobId] == nil {
+		b.subs[jobId] = map[chan string]struct{}{}
+	}
+	b.subs[jobId][ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		delete(b.subs[jobId], ch)
+		b.mu.Unlock()
+		close(ch)
+	}
+	return
+}
+
+// Publish fans a log line out to every subscriber of jobId. A subscriber
+// with a full buffer has the line dropped rather than blocking the job.
+func (b *LogBroker) Publish(jobId uint32, line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[jobId] {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
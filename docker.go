@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	docker "github.com/docker/docker/client"
+)
+
+// dockerExecutor runs a step inside a Docker container, binding the
+// repository checkout into /app. This is the original, pre-Executor
+// behavior of DuckCI.Run.
+type dockerExecutor struct {
+	client      *docker.Client
+	containerID string
+}
+
+func (e *dockerExecutor) Prepare(ctx context.Context, step Step) error {
+	client, err := docker.NewClientWithOpts(docker.FromEnv)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %v", err)
+	}
+	e.client = client
+	return e.ensureImage(ctx, step.Image)
+}
+
+func (e *dockerExecutor) Run(ctx context.Context, step Step, workDir string, out io.Writer) (int, error) {
+	containerID, err := e.createAndStartContainer(ctx, step, workDir)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create and start container: %v", err)
+	}
+	e.containerID = containerID
+
+	if err := e.streamLogs(ctx, containerID, out); err != nil {
+		e.killOnCancel(ctx)
+		return -1, fmt.Errorf("failed to stream logs: %v", err)
+	}
+
+	statusCode, err := e.waitForContainer(ctx, containerID)
+	if err != nil {
+		e.killOnCancel(ctx)
+		return -1, fmt.Errorf("failed to wait for container: %v", err)
+	}
+	return int(statusCode), nil
+}
+
+// killOnCancel kills the step's container if ctx was canceled, since the
+// Docker API calls above abandon the container rather than stopping it.
+func (e *dockerExecutor) killOnCancel(ctx context.Context) {
+	if ctx.Err() == nil || e.client == nil || e.containerID == "" {
+		return
+	}
+	if err := e.client.ContainerKill(context.Background(), e.containerID, ""); err != nil {
+		log.Printf("failed to kill container %s: %v", e.containerID, err)
+	}
+}
+
+func (e *dockerExecutor) Cleanup(ctx context.Context) error {
+	if e.client == nil || e.containerID == "" {
+		return nil
+	}
+	// Cleanup runs after a canceled ctx too, so always remove with a fresh context.
+	return e.client.ContainerRemove(context.Background(), e.containerID, types.ContainerRemoveOptions{})
+}
+
+func (e *dockerExecutor) ensureImage(ctx context.Context, imageName string) error {
+	images, err := e.client.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if tag == imageName {
+				return nil
+			}
+		}
+	}
+
+	out, err := e.client.ImagePull(ctx, imageName, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(io.Discard, out)
+	return err
+}
+
+func (e *dockerExecutor) createAndStartContainer(ctx context.Context, step Step, repoPath string) (string, error) {
+	containerConfig := &container.Config{
+		Image:      step.Image,
+		WorkingDir: "/app",
+		Cmd:        []string{"/bin/sh", "-c", step.Runs},
+		Tty:        true,
+	}
+
+	hostConfig := &container.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:/app", repoPath)},
+	}
+
+	resp, err := e.client.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+
+	err = e.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+func (e *dockerExecutor) streamLogs(ctx context.Context, containerID string, out io.Writer) error {
+	logs, err := e.client.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		fmt.Fprintln(out, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func (e *dockerExecutor) waitForContainer(ctx context.Context, containerID string) (int64, error) {
+	statusCh, errCh := e.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return -1, err
+	case status := <-statusCh:
+		return status.StatusCode, nil
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Executor runs a single CI step in some execution environment, such as a
+// Docker container or the local shell.
+type Executor interface {
+	// Prepare readies the executor to run the given step, e.g. pulling an image.
+	Prepare(ctx context.Context, step Step) error
+	// Run executes the step's command against workDir, streaming combined
+	// stdout/stderr to out, and returns the process exit code.
+	Run(ctx context.Context, step Step, workDir string, out io.Writer) (exitCode int, err error)
+	// Cleanup releases any resources allocated by Prepare/Run.
+	Cleanup(ctx context.Context) error
+}
+
+// ExecutorFactory creates a new Executor instance.
+type ExecutorFactory func() Executor
+
+var executors = map[string]ExecutorFactory{}
+
+// RegisterExecutor makes an executor backend available under name, for use
+// as a step's or project's `runner` in duck-ci.yml.
+func RegisterExecutor(name string, factory ExecutorFactory) {
+	executors[name] = factory
+}
+
+// NewExecutor looks up a registered executor backend by name. An empty name
+// falls back to the "docker" backend for backwards compatibility.
+func NewExecutor(name string) (Executor, error) {
+	if name == "" {
+		name = "docker"
+	}
+	factory, ok := executors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runner: %s", name)
+	}
+	return factory(), nil
+}
+
+func init() {
+	RegisterExecutor("docker", func() Executor { return &dockerExecutor{} })
+	RegisterExecutor("shell", func() Executor { return &shellExecutor{} })
+}
+
+// shellExecutor runs a step's command directly on the host, without Docker.
+type shellExecutor struct{}
+
+func (e *shellExecutor) Prepare(ctx context.Context, step Step) error {
+	return nil
+}
+
+func (e *shellExecutor) Run(ctx context.Context, step Step, workDir string, out io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", step.Runs)
+	cmd.Dir = workDir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
+func (e *shellExecutor) Cleanup(ctx context.Context) error {
+	return nil
+}
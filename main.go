@@ -1,31 +1,74 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
-	port string
+	port        string
+	workers     int
+	gracePeriod time.Duration
 )
 
 func main() {
 
 	flag.StringVar(&port, "port", "4000", "http port")
+	flag.IntVar(&workers, "workers", 0, "max concurrent jobs (default: number of CPUs)")
+	flag.DurationVar(&gracePeriod, "grace-period", 30*time.Second, "time to let in-flight jobs finish on shutdown before canceling them")
 	flag.Parse()
 
 	config := DuckCIConfig{
-		Database: "duckci.db",
+		Database:      "duckci.db",
+		MaxConcurrent: workers,
 	}
 	ci, err := New(config)
 	if err != nil {
 		log.Fatal(err)
 	}
-	http.HandleFunc("/", ci.IndexView)
-	http.HandleFunc("/new", ci.ProjectView)
-	http.HandleFunc("/projects", ci.ProjectView)
-	http.HandleFunc("/task", ci.TaskView)
-	http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ci.IndexView)
+	mux.HandleFunc("/new", ci.ProjectView)
+	mux.HandleFunc("/projects", ci.ProjectView)
+	mux.HandleFunc("/task", ci.TaskView)
+	mux.HandleFunc("/task/cancel", ci.CancelView)
+	mux.HandleFunc("/task/logs", ci.LogsView)
+	mux.HandleFunc("/webhooks/", ci.WebhookView)
+	mux.HandleFunc("/queue", ci.QueueView)
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: mux,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	<-sig
+
+	log.Println("shutting down: no longer accepting connections")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("http shutdown:", err)
+	}
+
+	log.Println("shutting down: draining in-flight jobs")
+	if err := ci.Close(gracePeriod); err != nil {
+		log.Println("duck-ci close:", err)
+	}
+	log.Println("shutdown complete")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+)
+
+// Scheduler runs queued jobs with a bounded number of concurrent workers, so
+// a burst of submissions can't launch unlimited Docker builds at once.
+type Scheduler struct {
+	ci            *DuckCI
+	maxConcurrent int
+	wake          chan struct{}
+
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewScheduler creates a Scheduler with maxConcurrent worker goroutines. A
+// maxConcurrent <= 0 defaults to runtime.NumCPU().
+func NewScheduler(ci *DuckCI, maxConcurrent int) *Scheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = runtime.NumCPU()
+	}
+	return &Scheduler{
+		ci:            ci,
+		maxConcurrent: maxConcurrent,
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start requeues any jobs left running from a previous crash, then launches
+// the worker pool.
+func (sch *Scheduler) Start() error {
+	if err := sch.ci.db.requeueRunningJobs(); err != nil {
+		return err
+	}
+	for i := 0; i < sch.maxConcurrent; i++ {
+		sch.wg.Add(1)
+		go sch.worker()
+	}
+	return nil
+}
+
+// Notify wakes a worker to check the queue, e.g. after a new job is enqueued.
+func (sch *Scheduler) Notify() {
+	select {
+	case sch.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop signals all workers to finish their current job and exit, then waits
+// for them.
+func (sch *Scheduler) Stop() {
+	sch.stopOnce.Do(func() { close(sch.stop) })
+	sch.wg.Wait()
+}
+
+func (sch *Scheduler) worker() {
+	defer sch.wg.Done()
+	for {
+		select {
+		case <-sch.stop:
+			return
+		default:
+		}
+
+		job, ok, err := sch.ci.db.claimNextQueuedJob()
+		if err != nil {
+			log.Println(err)
+		}
+		if !ok {
+			select {
+			case <-sch.stop:
+				return
+			case <-sch.wake:
+				continue
+			}
+		}
+
+		sch.ci.runQueuedJob(&job)
+	}
+}
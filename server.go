@@ -1,33 +1,51 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	docker "github.com/docker/docker/client"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/song940/duckci/templates"
 	"gopkg.in/yaml.v2"
 )
 
+// Job statuses. A job is queued, then running, then moves to exactly one
+// terminal state; a job canceled while still queued goes straight to canceled.
+const (
+	JobStatusQueued   = "queued"
+	JobStatusRunning  = "running"
+	JobStatusSuccess  = "success"
+	JobStatusFailed   = "failed"
+	JobStatusCanceled = "canceled"
+)
+
 type DuckCI struct {
-	db *Storage
+	db        *Storage
+	logs      *LogBroker
+	scheduler *Scheduler
+
+	cancelsMu sync.Mutex
+	cancels   map[uint32]context.CancelFunc
+
+	donesMu sync.Mutex
+	dones   map[uint32]chan struct{}
 }
 
 type DuckCIConfig struct {
-	Database string
+	Database      string
+	MaxConcurrent int
 }
 
 type Storage struct {
@@ -35,15 +53,17 @@ type Storage struct {
 }
 
 type Project struct {
-	Id        uint32    `json:"id"`
-	Name      string    `json:"name"`
-	Repo      string    `json:"repo"`
-	CreatedAt time.Time `json:"created_at"`
+	Id            uint32    `json:"id"`
+	Name          string    `json:"name"`
+	Repo          string    `json:"repo"`
+	WebhookSecret string    `json:"webhook_secret"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type Job struct {
 	Id        uint32    `json:"id"`
 	Branch    string    `json:"branch"`
+	Commit    string    `json:"commit"`
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 
@@ -58,13 +78,15 @@ type Log struct {
 }
 
 type Step struct {
-	Name  string `yaml:"name"`
-	Image string `yaml:"image"`
-	Runs  string `yaml:"runs"`
+	Name   string `yaml:"name"`
+	Image  string `yaml:"image"`
+	Runs   string `yaml:"runs"`
+	Runner string `yaml:"runner"`
 }
 
 type Config struct {
-	Steps []Step `yaml:"steps"`
+	Runner string `yaml:"runner"`
+	Steps  []Step `yaml:"steps"`
 }
 
 type H map[string]interface{}
@@ -75,31 +97,68 @@ func New(config DuckCIConfig) (ci *DuckCI, err error) {
 		return
 	}
 	ci = &DuckCI{
-		db: storage,
+		db:      storage,
+		logs:    NewLogBroker(),
+		cancels: map[uint32]context.CancelFunc{},
+		dones:   map[uint32]chan struct{}{},
 	}
 	storage.Init()
+	ci.scheduler = NewScheduler(ci, config.MaxConcurrent)
+	err = ci.scheduler.Start()
 	return
 }
 
 func NewStorage(path string) (storage *Storage, err error) {
-	db, err := sql.Open("sqlite3", path)
+	// WAL mode lets readers and a writer proceed concurrently, and the busy
+	// timeout makes a worker wait for a lock instead of failing with
+	// SQLITE_BUSY when MaxConcurrent workers write at once.
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return
+	}
 	storage = &Storage{db}
 	return
 }
 
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// withTx runs fn inside a transaction, committing if it returns nil and
+// rolling back otherwise.
+func (s *Storage) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx, so the same query
+// helpers can run standalone or as part of a transaction.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 func (s *Storage) Init() error {
 	sql := `
 		create table projects (
 			id integer not null primary key,
 			name text not null,
 			repo text not null,
+			webhook_secret text not null default '',
 			created_at timestamp default CURRENT_TIMESTAMP
 		);
 		create table jobs (
 			id integer not null primary key,
 			project_id integer not null,
 			branch text not null,
-			status integer not null,
+			commit_sha text not null default '',
+			status text not null,
 			created_at timestamp default CURRENT_TIMESTAMP,
 			foreign key (project_id) references projects(id)
 		);
@@ -115,16 +174,17 @@ func (s *Storage) Init() error {
 	return err
 }
 
-func (s *Storage) createProject(name string, repo string) (project Project, err error) {
-	sql := `INSERT INTO projects (name, repo) VALUES (?, ?) RETURNING id`
-	err = s.db.QueryRow(sql, name, repo).Scan(&project.Id)
+func (s *Storage) createProject(name string, repo string, webhookSecret string) (project Project, err error) {
+	sql := `INSERT INTO projects (name, repo, webhook_secret) VALUES (?, ?, ?) RETURNING id`
+	err = s.db.QueryRow(sql, name, repo, webhookSecret).Scan(&project.Id)
 	project.Name = name
 	project.Repo = repo
+	project.WebhookSecret = webhookSecret
 	return
 }
 
 func (s *Storage) listProjects() (projects []Project, err error) {
-	sql := `SELECT id, name, repo, created_at FROM projects`
+	sql := `SELECT id, name, repo, webhook_secret, created_at FROM projects`
 	rows, err := s.db.Query(sql)
 	if err != nil {
 		return
@@ -133,9 +193,9 @@ func (s *Storage) listProjects() (projects []Project, err error) {
 
 	var project Project
 	for rows.Next() {
-		err := rows.Scan(&project.Id, &project.Name, &project.Repo, &project.CreatedAt)
+		err = rows.Scan(&project.Id, &project.Name, &project.Repo, &project.WebhookSecret, &project.CreatedAt)
 		if err != nil {
-			log.Fatal(err)
+			return
 		}
 		projects = append(projects, project)
 	}
@@ -143,30 +203,57 @@ func (s *Storage) listProjects() (projects []Project, err error) {
 }
 
 func (s *Storage) getProjectById(id string) (project Project, err error) {
-	sql := `select id, name, repo, created_at from projects where id = ?`
-	err = s.db.QueryRow(sql, id).Scan(&project.Id, &project.Name, &project.Repo, &project.CreatedAt)
+	sql := `select id, name, repo, webhook_secret, created_at from projects where id = ?`
+	err = s.db.QueryRow(sql, id).Scan(&project.Id, &project.Name, &project.Repo, &project.WebhookSecret, &project.CreatedAt)
+	return
+}
+
+func (s *Storage) getProjectByRepo(repo string) (project Project, err error) {
+	sql := `select id, name, repo, webhook_secret, created_at from projects where repo = ?`
+	err = s.db.QueryRow(sql, repo).Scan(&project.Id, &project.Name, &project.Repo, &project.WebhookSecret, &project.CreatedAt)
+	return
+}
+
+func (s *Storage) createJob(projectId, branch, commit string) (job Job, err error) {
+	err = s.withTx(context.Background(), func(tx *sql.Tx) error {
+		sql := `INSERT INTO jobs (project_id, branch, commit_sha, status) VALUES (?, ?, ?, ?) RETURNING id`
+		var id uint32
+		if err := tx.QueryRow(sql, projectId, branch, commit, JobStatusQueued).Scan(&id); err != nil {
+			return err
+		}
+		scanned, err := scanJobById(tx, fmt.Sprint(id))
+		job = scanned
+		return err
+	})
 	return
 }
 
-func (s *Storage) createJob(projectId, branch string) (job Job, err error) {
-	sql := `INSERT INTO jobs (project_id, branch, status) VALUES (?, ?, ?) RETURNING id`
-	err = s.db.QueryRow(sql, projectId, branch, -1).Scan(&job.Id)
+func (s *Storage) getJobsByProjectId(id uint32) (jobs []Job, err error) {
+	sql := `select id, branch, commit_sha, status, created_at from jobs where project_id = ? order by created_at desc`
+	rows, err := s.db.Query(sql, id)
 	if err != nil {
 		return
 	}
-	job, err = s.getJobById(fmt.Sprint(job.Id))
+	var job Job
+	for rows.Next() {
+		err = rows.Scan(&job.Id, &job.Branch, &job.Commit, &job.Status, &job.CreatedAt)
+		if err != nil {
+			return
+		}
+		jobs = append(jobs, job)
+	}
 	return
 }
 
-func (s *Storage) getJobsByProjectId(id uint32) (jobs []Job, err error) {
-	sql := `select id, branch, status, created_at from jobs where project_id = ? order by created_at desc`
-	rows, err := s.db.Query(sql, id)
+func (s *Storage) getJobsByStatus(status string) (jobs []Job, err error) {
+	sql := `select id, branch, commit_sha, status, created_at from jobs where status = ? order by created_at asc`
+	rows, err := s.db.Query(sql, status)
 	if err != nil {
 		return
 	}
 	var job Job
 	for rows.Next() {
-		err = rows.Scan(&job.Id, &job.Branch, &job.Status, &job.CreatedAt)
+		err = rows.Scan(&job.Id, &job.Branch, &job.Commit, &job.Status, &job.CreatedAt)
 		if err != nil {
 			return
 		}
@@ -175,34 +262,101 @@ func (s *Storage) getJobsByProjectId(id uint32) (jobs []Job, err error) {
 	return
 }
 
-func (s *Storage) updateJobStatus(jobId uint32, status int64) {
-	sql := `update jobs set status = ? where id = ?`
-	_, err := s.db.Exec(sql, status, jobId)
+func (s *Storage) getRecentJobs(limit int) (jobs []Job, err error) {
+	sql := `select id, branch, commit_sha, status, created_at from jobs order by created_at desc limit ?`
+	rows, err := s.db.Query(sql, limit)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
+	var job Job
+	for rows.Next() {
+		err = rows.Scan(&job.Id, &job.Branch, &job.Commit, &job.Status, &job.CreatedAt)
+		if err != nil {
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	return
 }
 
-func (s *Storage) inertLog(jobId uint32, t string) {
-	sql := `insert into logs (job_id, log) values (?, ?)`
-	_, err := s.db.Exec(sql, jobId, t)
+// claimNextQueuedJob atomically transitions the oldest queued job to
+// running and returns it, so concurrent workers never claim the same job.
+func (s *Storage) claimNextQueuedJob() (job Job, ok bool, err error) {
+	err = s.withTx(context.Background(), func(tx *sql.Tx) error {
+		query := `
+			UPDATE jobs SET status = ?
+			WHERE id = (
+				SELECT id FROM jobs WHERE status = ? ORDER BY created_at ASC LIMIT 1
+			)
+			RETURNING id
+		`
+		var id uint32
+		if err := tx.QueryRow(query, JobStatusRunning, JobStatusQueued).Scan(&id); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+		scanned, err := scanJobById(tx, fmt.Sprint(id))
+		if err != nil {
+			return err
+		}
+		job, ok = scanned, true
+		return nil
+	})
+	return
+}
+
+// requeueRunningJobs moves any jobs left in "running" back to "queued",
+// recovering jobs that were in flight when the process last exited.
+func (s *Storage) requeueRunningJobs() error {
+	sql := `update jobs set status = ? where status = ?`
+	_, err := s.db.Exec(sql, JobStatusQueued, JobStatusRunning)
+	return err
+}
+
+// cancelQueuedJob cancels a job that hasn't started running yet. It reports
+// whether a queued job was found and canceled.
+func (s *Storage) cancelQueuedJob(jobId uint32) (bool, error) {
+	sql := `update jobs set status = ? where id = ? and status = ?`
+	res, err := s.db.Exec(sql, JobStatusCanceled, jobId, JobStatusQueued)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Storage) updateJobStatus(jobId uint32, status string) error {
+	sql := `update jobs set status = ? where id = ?`
+	_, err := s.db.Exec(sql, status, jobId)
+	return err
+}
+
+func (s *Storage) inertLog(jobId uint32, t string) error {
+	sql := `insert into logs (job_id, log) values (?, ?)`
+	_, err := s.db.Exec(sql, jobId, t)
+	return err
 }
 
 func (s *Storage) getJobById(id string) (job Job, err error) {
+	return scanJobById(s.db, id)
+}
+
+// scanJobById runs the job-by-id query against q, so it can be reused both
+// standalone (*sql.DB) and inside a transaction (*sql.Tx).
+func scanJobById(q rowQuerier, id string) (job Job, err error) {
 	job.Project = Project{}
 	sql := `
-		SELECT 
-			p.id, p.name, p.repo, 
-			j.id, j.branch, j.status, j.created_at
-		FROM jobs j, projects p 
+		SELECT
+			p.id, p.name, p.repo, p.webhook_secret,
+			j.id, j.branch, j.commit_sha, j.status, j.created_at
+		FROM jobs j, projects p
 		WHERE j.project_id = p.id and j.id = ?
 	`
-	err = s.db.QueryRow(sql, id).Scan(
-		&job.Project.Id, &job.Project.Name, &job.Project.Repo,
-		&job.Id, &job.Branch, &job.Status, &job.CreatedAt,
+	err = q.QueryRow(sql, id).Scan(
+		&job.Project.Id, &job.Project.Name, &job.Project.Repo, &job.Project.WebhookSecret,
+		&job.Id, &job.Branch, &job.Commit, &job.Status, &job.CreatedAt,
 	)
 	return
 }
@@ -262,7 +416,12 @@ func (ci *DuckCI) ProjectView(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		name := r.Form.Get("name")
 		repo := r.Form.Get("repo")
-		project, err := ci.db.createProject(name, repo)
+		webhookSecret, err := generateWebhookSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		project, err := ci.db.createProject(name, repo, webhookSecret)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -307,52 +466,250 @@ func (ci *DuckCI) TaskView(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		projectId := r.FormValue("project")
 		branch := r.FormValue("branch")
-		job, err := ci.db.createJob(projectId, branch)
+		job, done, err := ci.enqueueJob(projectId, branch, "")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		go ci.Run(&job)
+
+		if cn, ok := w.(http.CloseNotifier); ok {
+			go func() {
+				select {
+				case <-cn.CloseNotify():
+					ci.cancelJob(job.Id)
+				case <-done:
+				}
+			}()
+		}
+
 		http.Redirect(w, r, fmt.Sprintf("/task?id=%d", job.Id), http.StatusFound)
 	}
 }
 
+// enqueueJob creates a queued job for projectId and wakes the scheduler to
+// run it, returning immediately. It is shared by the manual "new task"
+// form and the webhook receivers. The returned channel is closed once the
+// job reaches a terminal state.
+func (ci *DuckCI) enqueueJob(projectId, branch, commit string) (job Job, done chan struct{}, err error) {
+	job, err = ci.db.createJob(projectId, branch, commit)
+	if err != nil {
+		return
+	}
+	done = ci.trackDone(job.Id)
+	ci.scheduler.Notify()
+	return
+}
+
+// runQueuedJob runs a job claimed from the queue by a Scheduler worker,
+// registering it for cancellation while it runs and closing its done
+// channel once it finishes.
+func (ci *DuckCI) runQueuedJob(job *Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ci.trackCancel(job.Id, cancel)
+	ci.Run(ctx, job)
+	ci.untrackCancel(job.Id)
+	ci.finishDone(job.Id)
+}
+
+// QueueView handles GET /queue, showing queued, running, and recently
+// finished jobs.
+func (ci *DuckCI) QueueView(w http.ResponseWriter, r *http.Request) {
+	queued, err := ci.db.getJobsByStatus(JobStatusQueued)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	running, err := ci.db.getJobsByStatus(JobStatusRunning)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recent, err := ci.db.getRecentJobs(20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ci.Render(w, "queue", H{
+		"queued":  queued,
+		"running": running,
+		"recent":  recent,
+	})
+}
+
+// CancelView handles POST /task/cancel?id=, canceling a queued or running job.
+func (ci *DuckCI) CancelView(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	jobId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !ci.cancelJob(uint32(jobId)) {
+		http.Error(w, "job is not running", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/task?id=%s", id), http.StatusFound)
+}
+
+// LogsView handles GET /task/logs?id=&follow=1, streaming a job's log as
+// Server-Sent Events: historical rows first, then (if follow=1) new lines as
+// they're produced, the equivalent of `docker logs --follow`.
+func (ci *DuckCI) LogsView(w http.ResponseWriter, r *http.Request) {
+	taskId := r.URL.Query().Get("id")
+	jobId, err := strconv.ParseUint(taskId, 10, 32)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before reading history, so a line PushLog'd in between is
+	// never dropped: at worst it shows up once in history and once live.
+	follow := r.URL.Query().Get("follow") == "1"
+	var ch chan string
+	if follow {
+		var unsubscribe func()
+		ch, unsubscribe = ci.logs.Subscribe(uint32(jobId))
+		defer unsubscribe()
+	}
+
+	logs, err := ci.db.getLogsByJobId(taskId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for _, l := range logs {
+		fmt.Fprintf(w, "data: %s\n\n", l.Log)
+	}
+	flusher.Flush()
+
+	if !follow {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", line)
+			flusher.Flush()
+		}
+	}
+}
+
+// trackCancel registers the CancelFunc for a running job, so it can later be
+// canceled via cancelJob.
+func (ci *DuckCI) trackCancel(jobId uint32, cancel context.CancelFunc) {
+	ci.cancelsMu.Lock()
+	defer ci.cancelsMu.Unlock()
+	ci.cancels[jobId] = cancel
+}
+
+// untrackCancel removes a job's CancelFunc once it has reached a terminal state.
+func (ci *DuckCI) untrackCancel(jobId uint32) {
+	ci.cancelsMu.Lock()
+	defer ci.cancelsMu.Unlock()
+	delete(ci.cancels, jobId)
+}
+
+// cancelJob cancels a job, whether it is still queued or already running. It
+// reports whether a cancelable job was found.
+func (ci *DuckCI) cancelJob(jobId uint32) bool {
+	ci.cancelsMu.Lock()
+	cancel, ok := ci.cancels[jobId]
+	ci.cancelsMu.Unlock()
+	if ok {
+		cancel()
+		return true
+	}
+
+	canceled, err := ci.db.cancelQueuedJob(jobId)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if canceled {
+		ci.finishDone(jobId)
+	}
+	return canceled
+}
+
+// trackDone registers a channel that's closed once jobId reaches a terminal
+// state, so callers can wait on a job they just enqueued.
+func (ci *DuckCI) trackDone(jobId uint32) chan struct{} {
+	done := make(chan struct{})
+	ci.donesMu.Lock()
+	ci.dones[jobId] = done
+	ci.donesMu.Unlock()
+	return done
+}
+
+// finishDone closes and forgets jobId's done channel, if any.
+func (ci *DuckCI) finishDone(jobId uint32) {
+	ci.donesMu.Lock()
+	done, ok := ci.dones[jobId]
+	if ok {
+		delete(ci.dones, jobId)
+	}
+	ci.donesMu.Unlock()
+	if ok {
+		close(done)
+	}
+}
+
 func (ci *DuckCI) PushLog(job *Job, s string) {
-	ci.db.inertLog(job.Id, s)
+	if err := ci.db.inertLog(job.Id, s); err != nil {
+		log.Println("push log:", err)
+	}
+	ci.logs.Publish(job.Id, s)
 	log.Println(s)
 }
 
-func (ci *DuckCI) Run(job *Job) {
+func (ci *DuckCI) Run(ctx context.Context, job *Job) {
 	ci.PushLog(job, fmt.Sprintf("Starting job for project: %s", job.Project.Name))
 
-	repoPath, err := ci.cloneRepository(job)
+	repoPath, err := ci.cloneRepository(ctx, job)
 	if err != nil {
 		ci.PushLog(job, fmt.Sprintf("Failed to clone repository: %v", err))
-		ci.updateJobStatus(job, -1)
+		ci.updateJobStatus(job, ci.terminalStatus(ctx, err))
 		return
 	}
 
 	config, err := ci.loadConfig(repoPath)
 	if err != nil {
 		ci.PushLog(job, fmt.Sprintf("Failed to load configuration: %v", err))
-		ci.updateJobStatus(job, -1)
-		return
-	}
-
-	client, err := docker.NewClientWithOpts(docker.FromEnv)
-	if err != nil {
-		ci.PushLog(job, fmt.Sprintf("Failed to create Docker client: %v", err))
-		ci.updateJobStatus(job, -1)
+		ci.updateJobStatus(job, ci.terminalStatus(ctx, err))
 		return
 	}
 
 	for i, step := range config.Steps {
 		ci.PushLog(job, fmt.Sprintf("Starting step %d: %s", i+1, step.Name))
 
-		err := ci.runStep(client, job, step, repoPath)
+		if step.Runner == "" {
+			step.Runner = config.Runner
+		}
+		err := ci.runStep(ctx, job, step, repoPath)
 		if err != nil {
 			ci.PushLog(job, fmt.Sprintf("Step %d failed: %v", i+1, err))
-			ci.updateJobStatus(job, -1)
+			ci.updateJobStatus(job, ci.terminalStatus(ctx, err))
 			return
 		}
 
@@ -360,12 +717,21 @@ func (ci *DuckCI) Run(job *Job) {
 	}
 
 	ci.PushLog(job, "Job completed successfully")
-	ci.updateJobStatus(job, 0)
+	ci.updateJobStatus(job, JobStatusSuccess)
+}
+
+// terminalStatus decides whether a job that failed did so because it was
+// canceled, so callers don't have to special-case ctx.Err() everywhere.
+func (ci *DuckCI) terminalStatus(ctx context.Context, err error) string {
+	if ctx.Err() == context.Canceled {
+		return JobStatusCanceled
+	}
+	return JobStatusFailed
 }
 
-func (ci *DuckCI) cloneRepository(job *Job) (string, error) {
+func (ci *DuckCI) cloneRepository(ctx context.Context, job *Job) (string, error) {
 	repoPath := fmt.Sprintf("/tmp/duck-ci/%s-task-%d", job.Project.Name, job.Id)
-	cmd := exec.Command("git", "clone", "-b", job.Branch, job.Project.Repo, repoPath)
+	cmd := exec.CommandContext(ctx, "git", "clone", "-b", job.Branch, job.Project.Repo, repoPath)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("git clone error: %v, output: %s", err, out)
@@ -388,30 +754,31 @@ func (ci *DuckCI) loadConfig(repoPath string) (*Config, error) {
 	return &config, nil
 }
 
-func (ci *DuckCI) runStep(client *docker.Client, job *Job, step Step, repoPath string) error {
-	err := ci.ensureImage(client, step.Image)
+// runStep executes a single step through the executor backend named by
+// step.Runner (falling back to the "docker" backend), streaming its output
+// to the job's log as it runs.
+func (ci *DuckCI) runStep(ctx context.Context, job *Job, step Step, repoPath string) error {
+	executor, err := NewExecutor(step.Runner)
 	if err != nil {
-		return fmt.Errorf("failed to ensure image: %v", err)
-	}
-
-	containerID, err := ci.createAndStartContainer(client, step, repoPath)
-	if err != nil {
-		return fmt.Errorf("failed to create and start container: %v", err)
+		return err
 	}
 
-	err = ci.streamLogs(client, job, containerID)
-	if err != nil {
-		return fmt.Errorf("failed to stream logs: %v", err)
+	if err := executor.Prepare(ctx, step); err != nil {
+		return fmt.Errorf("failed to prepare executor: %v", err)
 	}
+	defer func() {
+		if err := executor.Cleanup(ctx); err != nil {
+			ci.PushLog(job, fmt.Sprintf("Warning: failed to clean up executor: %v", err))
+		}
+	}()
 
-	statusCode, err := ci.waitForContainer(client, containerID)
-	if err != nil {
-		return fmt.Errorf("failed to wait for container: %v", err)
+	out := &jobLogWriter{ci: ci, job: job}
+	statusCode, err := executor.Run(ctx, step, repoPath, out)
+	if len(out.buf) > 0 {
+		ci.PushLog(job, strings.TrimSuffix(string(out.buf), "\r"))
 	}
-
-	err = client.ContainerRemove(context.Background(), containerID, types.ContainerRemoveOptions{})
 	if err != nil {
-		ci.PushLog(job, fmt.Sprintf("Warning: Failed to remove container: %v", err))
+		return err
 	}
 
 	if statusCode != 0 {
@@ -421,84 +788,30 @@ func (ci *DuckCI) runStep(client *docker.Client, job *Job, step Step, repoPath s
 	return nil
 }
 
-func (ci *DuckCI) ensureImage(client *docker.Client, imageName string) error {
-	images, err := client.ImageList(context.Background(), types.ImageListOptions{})
-	if err != nil {
-		return err
-	}
-
-	for _, image := range images {
-		for _, tag := range image.RepoTags {
-			if tag == imageName {
-				return nil
-			}
-		}
-	}
-
-	out, err := client.ImagePull(context.Background(), imageName, types.ImagePullOptions{})
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(io.Discard, out)
-	return err
-}
-
-func (ci *DuckCI) createAndStartContainer(client *docker.Client, step Step, repoPath string) (string, error) {
-	containerConfig := &container.Config{
-		Image:      step.Image,
-		WorkingDir: "/app",
-		Cmd:        []string{"/bin/sh", "-c", step.Runs},
-		Tty:        true,
-	}
-
-	hostConfig := &container.HostConfig{
-		Binds: []string{fmt.Sprintf("%s:/app", repoPath)},
-	}
-
-	resp, err := client.ContainerCreate(context.Background(), containerConfig, hostConfig, nil, nil, "")
-	if err != nil {
-		return "", err
-	}
-
-	err = client.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{})
-	if err != nil {
-		return "", err
-	}
-
-	return resp.ID, nil
+// jobLogWriter adapts a job's log to an io.Writer, pushing one log row per
+// line written to it.
+type jobLogWriter struct {
+	ci  *DuckCI
+	job *Job
+	buf []byte
 }
 
-func (ci *DuckCI) streamLogs(client *docker.Client, job *Job, containerID string) error {
-	out, err := client.ContainerLogs(context.Background(), containerID, types.ContainerLogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Follow:     true,
-	})
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	scanner := bufio.NewScanner(out)
-	for scanner.Scan() {
-		ci.PushLog(job, scanner.Text())
+func (w *jobLogWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		w.ci.PushLog(w.job, strings.TrimSuffix(string(line), "\r"))
 	}
-
-	return scanner.Err()
+	return len(p), nil
 }
 
-func (ci *DuckCI) waitForContainer(client *docker.Client, containerID string) (int64, error) {
-	statusCh, errCh := client.ContainerWait(context.Background(), containerID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		return -1, err
-	case status := <-statusCh:
-		return status.StatusCode, nil
+func (ci *DuckCI) updateJobStatus(job *Job, status string) {
+	if err := ci.db.updateJobStatus(job.Id, status); err != nil {
+		log.Println("update job status:", err)
 	}
 }
-
-func (ci *DuckCI) updateJobStatus(job *Job, status int64) {
-	ci.db.updateJobStatus(job.Id, status)
-}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Close stops the scheduler from claiming new jobs, waits up to gracePeriod
+// for jobs already running to finish on their own, then cancels any that are
+// still going (killing and removing their containers) before closing the
+// database. Safe to call once, typically from a shutdown signal handler.
+func (ci *DuckCI) Close(gracePeriod time.Duration) error {
+	stopped := make(chan struct{})
+	go func() {
+		ci.scheduler.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Println("duck-ci: all jobs finished")
+	case <-time.After(gracePeriod):
+		log.Println("duck-ci: grace period elapsed, canceling remaining jobs")
+		ci.cancelAll()
+		<-stopped
+	}
+
+	return ci.db.Close()
+}
+
+// cancelAll cancels every job currently tracked as running.
+func (ci *DuckCI) cancelAll() {
+	ci.cancelsMu.Lock()
+	cancels := make([]context.CancelFunc, 0, len(ci.cancels))
+	for _, cancel := range ci.cancels {
+		cancels = append(cancels, cancel)
+	}
+	ci.cancelsMu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
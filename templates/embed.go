@@ -0,0 +1,7 @@
+// Package templates embeds the HTML templates rendered by DuckCI.Render.
+package templates
+
+import "embed"
+
+//go:embed *.html
+var Files embed.FS
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// generateWebhookSecret returns a random hex-encoded secret for a new
+// project's webhook.
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// webhookPush is the repo/branch/commit extracted from a push event,
+// regardless of which provider sent it.
+type webhookPush struct {
+	Repo   string
+	Branch string
+	Commit string
+}
+
+// githubPushPayload covers the fields of a push event that duck-ci needs.
+// GitHub and Gitea use the same event shape.
+type githubPushPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+}
+
+func parseGithubPush(body []byte) (webhookPush, error) {
+	var p githubPushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return webhookPush{}, err
+	}
+	return webhookPush{
+		Repo:   p.Repository.CloneURL,
+		Branch: strings.TrimPrefix(p.Ref, "refs/heads/"),
+		Commit: p.After,
+	}, nil
+}
+
+// gitlabPushPayload covers the fields of a GitLab push event that duck-ci
+// needs.
+type gitlabPushPayload struct {
+	Ref         string `json:"ref"`
+	CheckoutSHA string `json:"checkout_sha"`
+	Project     struct {
+		GitHTTPURL string `json:"git_http_url"`
+	} `json:"project"`
+}
+
+func parseGitlabPush(body []byte) (webhookPush, error) {
+	var p gitlabPushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return webhookPush{}, err
+	}
+	return webhookPush{
+		Repo:   p.Project.GitHTTPURL,
+		Branch: strings.TrimPrefix(p.Ref, "refs/heads/"),
+		Commit: p.CheckoutSHA,
+	}, nil
+}
+
+// WebhookView handles POST /webhooks/{provider}, verifying the payload's
+// signature against the target project's webhook secret and enqueuing a job
+// for the pushed branch, the same as submitting the "new task" form.
+func (ci *DuckCI) WebhookView(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var push webhookPush
+	switch provider {
+	case "github", "gitea":
+		push, err = parseGithubPush(body)
+	case "gitlab":
+		push, err = parseGitlabPush(body)
+	default:
+		http.Error(w, "unknown webhook provider", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if push.Repo == "" || push.Branch == "" {
+		http.Error(w, "payload missing repository or branch", http.StatusBadRequest)
+		return
+	}
+
+	project, err := ci.db.getProjectByRepo(push.Repo)
+	if err != nil {
+		http.Error(w, "unknown repository", http.StatusNotFound)
+		return
+	}
+
+	if !verifyWebhookSignature(provider, project.WebhookSecret, body, r) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if _, _, err := ci.enqueueJob(fmt.Sprint(project.Id), push.Branch, push.Commit); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyWebhookSignature checks the request against a project's webhook
+// secret, using the scheme each provider documents for its push event.
+func verifyWebhookSignature(provider, secret string, body []byte, r *http.Request) bool {
+	switch provider {
+	case "github":
+		return verifyHMACSignature(secret, body, r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	case "gitea":
+		return verifyHMACSignature(secret, body, r.Header.Get("X-Gitea-Signature"), "")
+	case "gitlab":
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+	default:
+		return false
+	}
+}
+
+// verifyHMACSignature checks a hex HMAC-SHA256 signature of body against
+// secret, after stripping an optional scheme prefix (e.g. GitHub's "sha256=").
+func verifyHMACSignature(secret string, body []byte, signature, prefix string) bool {
+	signature = strings.TrimPrefix(signature, prefix)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
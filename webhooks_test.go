@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// githubPushSample is a trimmed version of the push event payload documented
+// at https://docs.github.com/en/webhooks/webhook-events-and-payloads#push.
+const githubPushSample = `{
+	"ref": "refs/heads/main",
+	"before": "9049f1265b7d61be4a8904a9a27120d2064dab3d",
+	"after": "0000000000000000000000000000000000000000",
+	"repository": {
+		"id": 1296269,
+		"full_name": "octocat/Hello-World",
+		"clone_url": "https://github.com/octocat/Hello-World.git",
+		"html_url": "https://github.com/octocat/Hello-World"
+	}
+}`
+
+// giteaPushSample mirrors Gitea's push webhook payload, documented at
+// https://docs.gitea.com/development/webhooks, which follows the same shape
+// as GitHub's push event.
+const giteaPushSample = `{
+	"ref": "refs/heads/develop",
+	"before": "ef98532add3b2feb7a137426bba1248724367df5",
+	"after": "cdb1ff06b19f833a9d0757a5a6d6f09a50f91a48",
+	"repository": {
+		"full_name": "gitea/webhooks",
+		"clone_url": "http://localhost:3000/gitea/webhooks.git",
+		"html_url": "http://localhost:3000/gitea/webhooks"
+	}
+}`
+
+// gitlabPushSample is a trimmed version of the push event payload documented
+// at https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#push-events.
+const gitlabPushSample = `{
+	"object_kind": "push",
+	"ref": "refs/heads/master",
+	"checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d",
+	"project": {
+		"name": "Diaspora",
+		"git_http_url": "http://example.com/mike/diaspora.git"
+	}
+}`
+
+func TestParseGithubPush(t *testing.T) {
+	push, err := parseGithubPush([]byte(githubPushSample))
+	if err != nil {
+		t.Fatalf("parseGithubPush: %v", err)
+	}
+	if push.Repo != "https://github.com/octocat/Hello-World.git" {
+		t.Errorf("Repo = %q", push.Repo)
+	}
+	if push.Branch != "main" {
+		t.Errorf("Branch = %q", push.Branch)
+	}
+	if push.Commit != "0000000000000000000000000000000000000000" {
+		t.Errorf("Commit = %q", push.Commit)
+	}
+}
+
+func TestParseGithubPush_Gitea(t *testing.T) {
+	// Gitea push events use the same shape as GitHub's.
+	push, err := parseGithubPush([]byte(giteaPushSample))
+	if err != nil {
+		t.Fatalf("parseGithubPush: %v", err)
+	}
+	if push.Repo != "http://localhost:3000/gitea/webhooks.git" {
+		t.Errorf("Repo = %q", push.Repo)
+	}
+	if push.Branch != "develop" {
+		t.Errorf("Branch = %q", push.Branch)
+	}
+	if push.Commit != "cdb1ff06b19f833a9d0757a5a6d6f09a50f91a48" {
+		t.Errorf("Commit = %q", push.Commit)
+	}
+}
+
+func TestParseGitlabPush(t *testing.T) {
+	push, err := parseGitlabPush([]byte(gitlabPushSample))
+	if err != nil {
+		t.Fatalf("parseGitlabPush: %v", err)
+	}
+	if push.Repo != "http://example.com/mike/diaspora.git" {
+		t.Errorf("Repo = %q", push.Repo)
+	}
+	if push.Branch != "master" {
+		t.Errorf("Branch = %q", push.Branch)
+	}
+	if push.Commit != "da1560886d4f094c3e6c9ef40349f7d38b5d27d" {
+		t.Errorf("Commit = %q", push.Commit)
+	}
+}
+
+func signHMAC(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature_Github(t *testing.T) {
+	secret := "It's a Secret to Everybody"
+
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/github", nil)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+signHMAC(secret, githubPushSample))
+	if !verifyWebhookSignature("github", secret, []byte(githubPushSample), r) {
+		t.Fatal("expected valid signature to verify")
+	}
+
+	r.Header.Set("X-Hub-Signature-256", "sha256=deadbeef")
+	if verifyWebhookSignature("github", secret, []byte(githubPushSample), r) {
+		t.Fatal("expected tampered signature to fail verification")
+	}
+}
+
+func TestVerifyWebhookSignature_Gitea(t *testing.T) {
+	secret := "gitea-secret"
+
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/gitea", nil)
+	r.Header.Set("X-Gitea-Signature", signHMAC(secret, giteaPushSample))
+	if !verifyWebhookSignature("gitea", secret, []byte(giteaPushSample), r) {
+		t.Fatal("expected valid signature to verify")
+	}
+
+	r.Header.Set("X-Gitea-Signature", "0000")
+	if verifyWebhookSignature("gitea", secret, []byte(giteaPushSample), r) {
+		t.Fatal("expected tampered signature to fail verification")
+	}
+}
+
+func TestVerifyWebhookSignature_Gitlab(t *testing.T) {
+	secret := "gitlab-token"
+
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/gitlab", nil)
+	r.Header.Set("X-Gitlab-Token", secret)
+	if !verifyWebhookSignature("gitlab", secret, []byte(gitlabPushSample), r) {
+		t.Fatal("expected matching token to verify")
+	}
+
+	r.Header.Set("X-Gitlab-Token", "wrong-token")
+	if verifyWebhookSignature("gitlab", secret, []byte(gitlabPushSample), r) {
+		t.Fatal("expected mismatched token to fail verification")
+	}
+}